@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// zstdMagic is the 4-byte little-endian frame magic identifying a zstd-compressed section,
+// per RFC 8878 section 3.1.1.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// decompressZlibSection returns the (decompressed, if necessary) contents of a section whose
+// data may follow the non-standard "ZLIB" + 8-byte big-endian uncompressed-size convention that
+// both debug/macho's and debug/pe's own DWARF() methods unpack for .debug_info et al. Data
+// without the "ZLIB" magic is returned unchanged.
+//
+// The standard library has no zstd decoder, and this tool has no other dependencies to pull
+// one in from, so a section that starts with the zstd frame magic is rejected with a clear
+// error rather than fed to the range-list parsers as garbage. A section with the "ZLIB" magic
+// is always decompressed with zlib.
+func decompressZlibSection(data []byte) ([]byte, error) {
+	if len(data) >= 4 && bytes.Equal(data[:4], zstdMagic) {
+		return nil, fmt.Errorf("section is zstd-compressed, which this tool doesn't support decompressing; recompile without --compress-debug-sections=zstd")
+	}
+	if len(data) < 12 || string(data[:4]) != "ZLIB" {
+		return data, nil
+	}
+	uncompressedLen := binary.BigEndian.Uint64(data[4:12])
+	uncompressed := make([]byte, uncompressedLen)
+	r, err := zlib.NewReader(bytes.NewReader(data[12:]))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if _, err := io.ReadFull(r, uncompressed); err != nil {
+		return nil, err
+	}
+	return uncompressed, nil
+}
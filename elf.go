@@ -0,0 +1,80 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+)
+
+// byteOrderForELF returns the byte order used to encode multi-byte values in file.
+func byteOrderForELF(file *elf.File) (binary.ByteOrder, error) {
+	switch file.Data {
+	case elf.ELFDATA2LSB:
+		return binary.LittleEndian, nil
+	case elf.ELFDATA2MSB:
+		return binary.BigEndian, nil
+	default:
+		return nil, fmt.Errorf("%v has an unknown byte order", file)
+	}
+}
+
+// bytesPerAddressForELF returns the size of an address in file, in bytes.
+func bytesPerAddressForELF(file *elf.File) (uint8, error) {
+	switch file.Class {
+	case elf.ELFCLASS32:
+		return 4, nil
+	case elf.ELFCLASS64:
+		return 8, nil
+	default:
+		return 0, fmt.Errorf("%v has unknown class value", file)
+	}
+}
+
+// elfSectionData returns the contents of the named section in file, or nil if it doesn't
+// exist.
+func elfSectionData(file *elf.File, name string) ([]byte, error) {
+	section := file.Section(name)
+	if section == nil {
+		return nil, nil
+	}
+	return section.Data()
+}
+
+// openELF opens path as an ELF file and extracts its DWARF data and debugSections.
+func openELF(path string) (*dwarf.Data, debugSections, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, debugSections{}, err
+	}
+	defer file.Close()
+
+	// Strictly speaking, dwarf.Data should have other debug sections too, but in practice,
+	// only .debug_info is exposed.
+	debugInfo, err := file.DWARF()
+	if err != nil {
+		return nil, debugSections{}, err
+	}
+
+	byteOrder, err := byteOrderForELF(file)
+	if err != nil {
+		return nil, debugSections{}, err
+	}
+	addressSize, err := bytesPerAddressForELF(file)
+	if err != nil {
+		return nil, debugSections{}, err
+	}
+
+	sections := debugSections{byteOrder: byteOrder, addressSize: addressSize}
+	if sections.ranges, err = elfSectionData(file, ".debug_ranges"); err != nil {
+		return nil, debugSections{}, err
+	}
+	if sections.rnglists, err = elfSectionData(file, ".debug_rnglists"); err != nil {
+		return nil, debugSections{}, err
+	}
+	if sections.addr, err = elfSectionData(file, ".debug_addr"); err != nil {
+		return nil, debugSections{}, err
+	}
+
+	return debugInfo, sections, nil
+}
@@ -2,111 +2,37 @@ package main
 
 import (
 	"debug/dwarf"
-	"debug/elf"
+	"debug/macho"
 	"encoding/binary"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"math"
+	"os"
 	"sort"
+	"strings"
 )
 
-var formatFlag = flag.String("format", "text", "Output format. Valid options are 'json' or 'text'.")
+var formatFlag = flag.String("format", "text", "Output format. Valid options are 'json', 'text', 'tree', or 'flamegraph'.")
 var limitFlag = flag.Uint64("limit", 100, "Number of entries to show. 0 = no limit.")
 var sortFlag = flag.String("sort", "total-bytes", "Sorting order. Valid options are 'count', 'instance-bytes', or 'total-bytes'.")
+var groupByFlag = flag.String("group-by", "callee", "How to aggregate inlined instances. Valid options are 'callee' or 'call-site'.")
 
 const attrLinkageName dwarf.Attr = 0x6e
 
-type rangeSizeMap map[int64]uint64
-
-// Go's debug/dwarf package doesn't include .debug_ranges parsing support.
-func parseDebugRangesFromELF(file *elf.File) (rangeSizeMap, error) {
-	log.Print("parsing .debug_ranges...")
-	section := file.Section(".debug_ranges")
-	if section == nil {
-		return nil, nil
-	}
-
-	var byteOrder binary.ByteOrder
-	switch file.Data {
-	case elf.ELFDATA2LSB:
-		byteOrder = binary.LittleEndian
-	case elf.ELFDATA2MSB:
-		byteOrder = binary.BigEndian
-	default:
-		return nil, fmt.Errorf("%v has an unknown byte order", file)
-	}
-
-	var bytesPerAddress uint8
-	switch file.Class {
-	case elf.ELFCLASS32:
-		bytesPerAddress = 4
-	case elf.ELFCLASS64:
-		bytesPerAddress = 8
-	default:
-		return nil, fmt.Errorf("%v has unknown class value", file)
-	}
-
-	// The .debug_ranges format is pretty simple. A DIE may use DW_AT_ranges to refer to a
-	// range in the .debug_ranges section, which represents a range of non-contiguous
-	// addresses. Each entry in the range is a either a range list entry, a base address
-	// selection entry, or an end of list entry.
-	// - A range list entry consists of a beginning address offset and an ending address
-	//   offset. The beginning address offset may be 0x0, and the length of the range may be
-	//   0, if the beginning and ending address offsets are equal. Range list entries may
-	//   not overlap.
-	// - A base address selection entry, which consists of the largest representable
-	//   address, e.g. 0xffffffff for 32-bit addresses, and an address that defines the base
-	//   address of subsequent entries.
-	// - An end of list entry is a range list entry that has a beginning and ending address
-	//   offset of 0.
-	var currentOffset, nextOffset int64
-	rangeSizes := make(rangeSizeMap)
-	buffer := make([]byte, 2*bytesPerAddress)
-	for reader := section.Open(); ; {
-		n, err := reader.Read(buffer)
-		if n == 0 && err == io.EOF {
-			return rangeSizes, nil
-		} else if n != len(buffer) {
-			return nil, fmt.Errorf("read strange number of bytes: %d", n)
-		} else if err != nil {
-			return nil, err
-		}
-		nextOffset += int64(n)
-		var begin, end uint64
-		switch file.Class {
-		case elf.ELFCLASS32:
-			begin = uint64(byteOrder.Uint32(buffer))
-			end = uint64(byteOrder.Uint32(buffer[4:]))
-			if begin == math.MaxUint32 {
-				continue
-			}
-		case elf.ELFCLASS64:
-			begin = byteOrder.Uint64(buffer)
-			end = byteOrder.Uint64(buffer[8:])
-			if begin == math.MaxUint64 {
-				continue
-			}
-		}
-		if begin == 0 && end == 0 {
-			currentOffset = nextOffset
-			continue
-		}
-		bytes := end - begin
-		if bytes < 0 {
-			return nil, fmt.Errorf("got invalid range %v", buffer)
-		}
-		rangeSizes[currentOffset] += bytes
-	}
-}
-
 type nameMap map[dwarf.Offset]string
+
+// specMap maps a DW_TAG_subprogram DIE's offset to the offset it should be resolved through
+// instead, via either DW_AT_specification (a declaration naming its out-of-line definition)
+// or DW_AT_abstract_origin (a concrete instance naming the abstract instance it was cloned
+// from). Both point at the DIE that actually carries the name.
 type specMap map[dwarf.Offset]dwarf.Offset
 
 // Attempts to extract a function name from the DIE at the provided offset. Unfortunately, since
-// it's C++ and DWARF, it's not just a simple matter of getting name attribute and returning it.
+// it's C++ and DWARF, it's not just a simple matter of getting name attribute and returning it:
+// the DIE may instead be a declaration or a concrete out-of-line instance that only points at
+// the DIE carrying the name via DW_AT_specification or DW_AT_abstract_origin.
 func nameForSubprogram(names nameMap, specs specMap, offset dwarf.Offset) (string, error) {
 	if specOffset, ok := specs[offset]; ok {
 		return nameForSubprogram(names, specs, specOffset)
@@ -114,59 +40,201 @@ func nameForSubprogram(names nameMap, specs specMap, offset dwarf.Offset) (strin
 	if name, ok := names[offset]; ok {
 		return name, nil
 	}
-	return "", fmt.Errorf("could not find name or spec for subprogram 0x%x", offset)
+	return "", fmt.Errorf("could not find name, specification, or abstract origin for subprogram 0x%x", offset)
 }
 
-func bytesForInlinedSubroutine(rangeSizes rangeSizeMap, entry *dwarf.Entry) (uint64, error) {
+// bytesForInlinedSubroutine computes the number of bytes of machine code attributed to an
+// inlined subroutine DIE. rangeSizes and rnglistSizes are the tables built from .debug_ranges
+// and .debug_rnglists respectively. A DW_FORM_sec_offset DW_AT_ranges value looks the same (an
+// int64 section offset) whichever section it points into, and a CU's DWARF version isn't a
+// reliable proxy for which one that is (e.g. gcc only emits DW_AT_rnglists_base when a
+// DW_FORM_rnglistx actually appears, not merely because the CU is DWARF5), so this instead
+// tries whichever table actually has the offset, preferring rnglistSizes since .debug_rnglists
+// is DW_AT_ranges' exclusively-DWARF5 form. sizeKnown is false, with a nil error, when the DIE
+// only has a lone DW_AT_low_pc: the instance is real, but this tool has no way to compute its
+// extent, so the caller should count the instance without adding to its byte total.
+func bytesForInlinedSubroutine(rangeSizes, rnglistSizes rangeSizeMap, entry *dwarf.Entry) (bytes uint64, sizeKnown bool, err error) {
 	// Per the DWARF spec, a DIE with associated machine code may have:
-	// - A DW_AT_low_pc attribute for a snigle address (not handled)
+	// - A DW_AT_low_pc attribute for a single address,
 	// - A DW_AT_low_pc and DW_AT_high_pc attribute for a single contiguous range of
 	//   addresses, or
 	// - A DW_AT_ranges attribute for a non-contiguous range of addresses.
 
-	// TODO(dcheng): This tool should be able to handle either form.
-	// The spec notes that DW_AT_high_pc may be either of class address or class constant.
-	// In the latter case, DW_AT_high_pc is an offset from DW_AT_low_pc which gives the
-	// first instruction past the last instruction associated with the DIE. This code
-	// assumes the latter, since that's what Clang emits and it makes the code simpler.
-	if bytes, ok := entry.Val(dwarf.AttrHighpc).(int64); ok {
-		if bytes < 0 {
-			return 0, fmt.Errorf("%v has negative size %d", entry, bytes)
+	// DW_AT_high_pc may be either of class address (an absolute end address, decoded by
+	// debug/dwarf as a uint64) or class constant (an offset from DW_AT_low_pc giving the
+	// first instruction past the last instruction associated with the DIE, decoded as an
+	// int64). Clang emits the latter; other producers, and linker/stripper output, may emit
+	// the former.
+	switch highPC := entry.Val(dwarf.AttrHighpc).(type) {
+	case int64:
+		if highPC < 0 {
+			return 0, false, fmt.Errorf("%v has negative size %d", entry, highPC)
+		}
+		return uint64(highPC), true, nil
+	case uint64:
+		lowPC, ok := entry.Val(dwarf.AttrLowpc).(uint64)
+		if !ok {
+			return 0, false, fmt.Errorf("%v has DW_AT_high_pc of class address but no DW_AT_low_pc", entry)
+		}
+		if highPC < lowPC {
+			return 0, false, fmt.Errorf("%v has high pc %d less than low pc %d", entry, highPC, lowPC)
 		}
-		return uint64(bytes), nil
+		return highPC - lowPC, true, nil
 	}
 
-	rangeOffset, ok := entry.Val(dwarf.AttrRanges).(int64)
-	if !ok {
-		return 0, fmt.Errorf("%v has no valid high pc or range", entry)
+	// DW_AT_ranges is either a DW_FORM_sec_offset pointing into .debug_ranges or
+	// .debug_rnglists (decoded by debug/dwarf as an int64), or a DW_FORM_rnglistx index that
+	// debug/dwarf has already resolved to an absolute .debug_rnglists offset (as a uint64)
+	// using the CU's DW_AT_rnglists_base.
+	switch rangesVal := entry.Val(dwarf.AttrRanges).(type) {
+	case int64:
+		if bytes, ok := rnglistSizes[rangesVal]; ok {
+			return bytes, true, nil
+		}
+		if bytes, ok := rangeSizes[rangesVal]; ok {
+			return bytes, true, nil
+		}
+		return 0, false, fmt.Errorf("couldn't find range entry for %v", entry)
+	case uint64:
+		bytes, ok := rnglistSizes[int64(rangesVal)]
+		if !ok {
+			return 0, false, fmt.Errorf("couldn't find range entry for %v", entry)
+		}
+		return bytes, true, nil
+	}
+
+	if _, ok := entry.Val(dwarf.AttrLowpc).(uint64); ok {
+		return 0, false, nil
+	}
+
+	return 0, false, fmt.Errorf("%v has no valid high pc or range", entry)
+}
+
+// callSiteForInlinedSubroutine resolves the caller-side source location of an inlined
+// subroutine DIE, for --group-by=call-site. It prefers the DW_AT_call_file/DW_AT_call_line
+// attributes DWARF producers normally attach to DW_TAG_inlined_subroutine, falling back to
+// looking up the DIE's low PC in the CU's line table for the rare producer that omits them.
+func callSiteForInlinedSubroutine(lineReader *dwarf.LineReader, entry *dwarf.Entry) (string, error) {
+	if lineReader == nil {
+		return "", fmt.Errorf("%v has no line table", entry)
+	}
+
+	if fileIdx, ok := entry.Val(dwarf.AttrCallFile).(int64); ok {
+		if line, ok := entry.Val(dwarf.AttrCallLine).(int64); ok {
+			files := lineReader.Files()
+			if fileIdx < 0 || int(fileIdx) >= len(files) || files[fileIdx] == nil {
+				return "", fmt.Errorf("%v has out-of-range call file %d", entry, fileIdx)
+			}
+			return fmt.Sprintf("%s:%d", files[fileIdx].Name, line), nil
+		}
 	}
-	bytes, ok := rangeSizes[rangeOffset]
+
+	lowPC, ok := entry.Val(dwarf.AttrLowpc).(uint64)
 	if !ok {
-		return 0, fmt.Errorf("couldn't find range entry for %v", entry)
+		return "", fmt.Errorf("%v has no call site information", entry)
+	}
+	var lineEntry dwarf.LineEntry
+	if err := lineReader.SeekPC(lowPC, &lineEntry); err != nil {
+		return "", fmt.Errorf("couldn't resolve call site for %v: %v", entry, err)
 	}
-	return bytes, nil
+	return fmt.Sprintf("%s:%d", lineEntry.File.Name, lineEntry.Line), nil
 }
 
 type stats struct {
-	Count uint64 // Number of times the function was inlined.
-	Bytes uint64 // Total bytes inlined for the function.
+	Count            uint64 // Number of times the function was inlined.
+	Bytes            uint64 // Total bytes inlined for the function.
+	UnknownSizeCount uint64 // Of Count, how many instances had a size this tool couldn't compute.
 }
 type result struct {
-	Name string
+	Name     string
+	CallSite string `json:",omitempty"` // Set when aggregating with --group-by=call-site.
 	stats
 }
 
-func analyze(file *elf.File) ([]*result, error) {
-	rangeSizes, err := parseDebugRangesFromELF(file)
-	if err != nil {
-		return nil, err
+// nameKey identifies an aggregation bucket in nameStats. CallSite is left blank when
+// aggregating by callee alone, so entries for the same callee collapse into one bucket
+// regardless of where they were inlined from.
+type nameKey struct {
+	Name     string
+	CallSite string
+}
+
+// abstractOriginKey identifies an inlined instance before name resolution. CallSite is
+// blank outside of --group-by=call-site, matching nameKey.
+type abstractOriginKey struct {
+	offset   dwarf.Offset
+	callSite string
+}
+
+// treeNode is one frame of the per-caller inlining tree built during the DIE walk in
+// analyze, before name resolution: a top-level DW_TAG_subprogram at the root, or a
+// DW_TAG_inlined_subroutine nested beneath one. Nodes are keyed by DIE offset -- the
+// subprogram's own offset for roots, the abstract origin's offset for inlined frames -- the
+// same offsets nameMap/specMap are keyed by, so resolveTree can resolve them the same way
+// nameForSubprogram does for the flat aggregation above. stats is zero for subprogram
+// nodes, since those aren't themselves inlined anywhere.
+type treeNode struct {
+	children map[dwarf.Offset]*treeNode
+	stats
+}
+
+func (n *treeNode) child(offset dwarf.Offset) *treeNode {
+	if n.children == nil {
+		n.children = make(map[dwarf.Offset]*treeNode)
+	}
+	c, ok := n.children[offset]
+	if !ok {
+		c = &treeNode{}
+		n.children[offset] = c
+	}
+	return c
+}
+
+// funcNode is a treeNode with its name resolved and children sorted, ready to print.
+type funcNode struct {
+	Name     string
+	Children []*funcNode `json:",omitempty"`
+	stats
+}
+
+// resolveTree resolves node's children into funcNodes, dropping subprogram frames that have
+// no inlined descendants (there's nothing to show for them) and sorting siblings by
+// descending bytes, ties broken by name, so the heaviest inlining shows up first at every
+// level.
+func resolveTree(node *treeNode, names nameMap, specs specMap) []*funcNode {
+	resolved := make([]*funcNode, 0, len(node.children))
+	for offset, child := range node.children {
+		children := resolveTree(child, names, specs)
+		if len(children) == 0 && child.Count == 0 {
+			continue
+		}
+		name, err := nameForSubprogram(names, specs, offset)
+		if err != nil {
+			log.Printf("error: couldn't extract name for %d: %v", offset, err)
+		}
+		resolved = append(resolved, &funcNode{
+			Name:     name,
+			Children: children,
+			stats:    child.stats,
+		})
 	}
+	sort.Slice(resolved, func(i, j int) bool {
+		if resolved[i].Bytes != resolved[j].Bytes {
+			return resolved[i].Bytes > resolved[j].Bytes
+		}
+		return resolved[i].Name < resolved[j].Name
+	})
+	return resolved
+}
 
-	// Strictly speaking, dwarf.Data should have other debug sections too, but in practice,
-	// only .debug_info is exposed.
-	debugInfo, err := file.DWARF()
+func analyze(debugInfo *dwarf.Data, sections debugSections) ([]*result, []*funcNode, error) {
+	rangeSizes, err := parseDebugRanges(sections)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	rnglistSizes, err := parseDebugRnglists(sections)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// DIEs may refer to a DIE with a greater offset, so defer name resolution until all DIEs
@@ -174,78 +242,148 @@ func analyze(file *elf.File) ([]*result, error) {
 	infoReader := debugInfo.Reader()
 	names := make(nameMap)
 	specs := make(specMap)
-	abstractOriginStats := make(map[dwarf.Offset]*stats)
+	abstractOriginStats := make(map[abstractOriginKey]*stats)
+	var lineReader *dwarf.LineReader
+	// tree mirrors abstractOriginStats as a per-caller tree for --format=tree and
+	// --format=flamegraph, and is only built for those formats: it's dead weight (and its
+	// name-resolution errors are pure noise) for the flat text/json output most invocations
+	// want. debug/dwarf's Reader.Next returns DIEs in depth-first order, terminating each
+	// parent's run of children with a synthetic zero-tag entry, so a stack of the tree nodes
+	// entered so far tracks the current nesting without an explicit recursive descent.
+	buildTree := *formatFlag == "tree" || *formatFlag == "flamegraph"
+	var tree *treeNode
+	var nodeStack []*treeNode
+	if buildTree {
+		tree = &treeNode{}
+		nodeStack = []*treeNode{tree}
+	}
 	for i := 0; ; i++ {
 		if i%1000000 == 0 {
 			log.Printf("read %d DIEs...", i)
 		}
 		entry, err := infoReader.Next()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if entry == nil {
 			break
 		}
+		if entry.Tag == 0 {
+			if buildTree {
+				nodeStack = nodeStack[:len(nodeStack)-1]
+			}
+			continue
+		}
+		if entry.Tag == dwarf.TagCompileUnit {
+			lineReader = nil
+			if *groupByFlag == "call-site" {
+				lineReader, err = debugInfo.LineReader(entry)
+				if err != nil {
+					log.Printf("error: couldn't read line table for %v: %v", entry, err)
+				}
+			}
+		}
+		var node *treeNode
+		if buildTree {
+			node = nodeStack[len(nodeStack)-1]
+		}
 		switch entry.Tag {
 		case dwarf.TagSubprogram:
 			if linkageName, ok := entry.Val(attrLinkageName).(string); ok {
 				names[entry.Offset] = linkageName
-				continue
-			}
-			if specOffset, ok := entry.Val(dwarf.AttrSpecification).(dwarf.Offset); ok {
+			} else if specOffset, ok := entry.Val(dwarf.AttrSpecification).(dwarf.Offset); ok {
 				specs[entry.Offset] = specOffset
-				continue
-			}
-			if name, ok := entry.Val(dwarf.AttrName).(string); ok {
+			} else if originOffset, ok := entry.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset); ok {
+				// A concrete out-of-line instance of a function that was also inlined
+				// elsewhere carries only DW_AT_abstract_origin and pc attributes, no
+				// name of its own; without this, its subtree in --format=tree/flamegraph
+				// would show up as a blank-named root disconnected from the correctly
+				// named node for the same function.
+				specs[entry.Offset] = originOffset
+			} else if name, ok := entry.Val(dwarf.AttrName).(string); ok {
 				names[entry.Offset] = name
-				continue
+			}
+			if buildTree {
+				node = node.child(entry.Offset)
 			}
 		case dwarf.TagInlinedSubroutine:
 			abstractOrigin, ok := entry.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
 			if !ok {
 				log.Printf("error: %v missing abstract origin", entry)
-				continue
+				break
 			}
-			bytes, err := bytesForInlinedSubroutine(rangeSizes, entry)
+			bytes, sizeKnown, err := bytesForInlinedSubroutine(rangeSizes, rnglistSizes, entry)
 			if err != nil {
 				log.Printf("error: %v", err)
-				continue
+				break
+			}
+			var callSite string
+			if *groupByFlag == "call-site" {
+				callSite, err = callSiteForInlinedSubroutine(lineReader, entry)
+				if err != nil {
+					log.Printf("error: %v", err)
+				}
 			}
-			s, ok := abstractOriginStats[abstractOrigin]
+			key := abstractOriginKey{offset: abstractOrigin, callSite: callSite}
+			s, ok := abstractOriginStats[key]
 			if !ok {
 				s = &stats{}
-				abstractOriginStats[abstractOrigin] = s
+				abstractOriginStats[key] = s
 			}
 			s.Count++
-			s.Bytes += bytes
+			if sizeKnown {
+				s.Bytes += bytes
+			} else {
+				s.UnknownSizeCount++
+			}
+
+			if buildTree {
+				node = node.child(abstractOrigin)
+				node.Count++
+				if sizeKnown {
+					node.Bytes += bytes
+				} else {
+					node.UnknownSizeCount++
+				}
+			}
+		}
+		if buildTree && entry.Children {
+			nodeStack = append(nodeStack, node)
 		}
 	}
 
 	log.Printf("resolving names for %d inlined functions", len(abstractOriginStats))
-	nameStats := make(map[string]*stats)
+	nameStats := make(map[nameKey]*stats)
 	for abstractOrigin, s := range abstractOriginStats {
-		name, err := nameForSubprogram(names, specs, abstractOrigin)
+		name, err := nameForSubprogram(names, specs, abstractOrigin.offset)
 		if err != nil {
-			log.Printf("error: couldn't extract name for %d: %v", abstractOrigin, err)
+			log.Printf("error: couldn't extract name for %d: %v", abstractOrigin.offset, err)
 		}
 
-		ns, ok := nameStats[name]
+		key := nameKey{Name: name, CallSite: abstractOrigin.callSite}
+		ns, ok := nameStats[key]
 		if !ok {
 			ns = &stats{}
-			nameStats[name] = ns
+			nameStats[key] = ns
 		}
 		ns.Count += s.Count
 		ns.Bytes += s.Bytes
+		ns.UnknownSizeCount += s.UnknownSizeCount
 	}
 
 	results := make([]*result, 0, len(nameStats))
 	for k, v := range nameStats {
 		results = append(results, &result{
-			Name: k,
-			stats: *v,
+			Name:     k.Name,
+			CallSite: k.CallSite,
+			stats:    *v,
 		})
 	}
-	return results, nil
+	var funcTree []*funcNode
+	if buildTree {
+		funcTree = resolveTree(tree, names, specs)
+	}
+	return results, funcTree, nil
 }
 
 type by func(r1, r2 *result) bool
@@ -298,10 +436,18 @@ func printSortedResults(results []*result, ordering string, format string, limit
 	results = results[:limit]
 	switch format {
 	case "text":
-		fmt.Printf("     Count      Bytes   Name\n")
-		fmt.Printf("  --------  ---------   ---------------------------------\n")
+		if *groupByFlag == "call-site" {
+			fmt.Printf("     Count      Bytes   Unknown   Name                                 Call site\n")
+			fmt.Printf("  --------  ---------   -------   -----------------------------------  ---------\n")
+			for _, r := range results {
+				fmt.Printf("%10d %10d   %7d   %-37s  %s\n", r.Count, r.Bytes, r.UnknownSizeCount, r.Name, r.CallSite)
+			}
+			break
+		}
+		fmt.Printf("     Count      Bytes   Unknown   Name\n")
+		fmt.Printf("  --------  ---------   -------   ---------------------------------\n")
 		for _, r := range results {
-			fmt.Printf("%10d %10d   %s\n", r.Count, r.Bytes, r.Name)
+			fmt.Printf("%10d %10d   %7d   %s\n", r.Count, r.Bytes, r.UnknownSizeCount, r.Name)
 		}
 	case "json":
 		b, err := json.Marshal(results)
@@ -313,11 +459,49 @@ func printSortedResults(results []*result, ordering string, format string, limit
 	}
 }
 
+// printTree prints roots, and everything inlined beneath them, as an indented per-caller
+// breakdown: each function, followed by what got inlined into it, recursively. --sort and
+// --limit don't apply here; roots and their children are already ordered by resolveTree.
+func printTree(roots []*funcNode) {
+	var print func(nodes []*funcNode, depth int)
+	print = func(nodes []*funcNode, depth int) {
+		for _, n := range nodes {
+			if n.Count > 0 {
+				fmt.Printf("%*s%s (count=%d, bytes=%d)\n", depth*2, "", n.Name, n.Count, n.Bytes)
+			} else {
+				fmt.Printf("%*s%s\n", depth*2, "", n.Name)
+			}
+			print(n.Children, depth+1)
+		}
+	}
+	print(roots, 0)
+}
+
+// printFlamegraph prints roots, and everything inlined beneath them, in the folded
+// "stack;stack;leaf count" format consumed by Brendan Gregg's flamegraph.pl, one line per
+// inlined frame. A frame's byte count includes any further inlining beneath it, so ancestor
+// frames may double-count bytes also attributed to a descendant's own line.
+func printFlamegraph(roots []*funcNode) {
+	var print func(nodes []*funcNode, stack []string)
+	print = func(nodes []*funcNode, stack []string) {
+		for _, n := range nodes {
+			frame := append(append([]string{}, stack...), n.Name)
+			if n.Count > 0 {
+				fmt.Printf("%s %d\n", strings.Join(frame, ";"), n.Bytes)
+			}
+			print(n.Children, frame)
+		}
+	}
+	print(roots, nil)
+}
+
 func main() {
 	flag.Parse()
 	switch *formatFlag {
 	case "json":
 	case "text":
+	case "tree":
+	case "flamegraph":
 	default:
 		log.Fatalf("error: invalid option for --format: %s", *formatFlag)
 	}
@@ -328,20 +512,69 @@ func main() {
 	default:
 		log.Fatalf("error: invalid option for --sort: %s", *sortFlag)
 	}
+	switch *groupByFlag {
+	case "callee":
+	case "call-site":
+	default:
+		log.Fatalf("error: invalid option for --group-by: %s", *groupByFlag)
+	}
 
 	for _, f := range flag.Args() {
 		log.Printf("analyzing %s...", f)
-		file, err := elf.Open(f)
+		debugInfo, sections, err := openObjectFile(f)
 		if err != nil {
 			log.Printf("error: couldn't open %s: %v", f, err)
 			continue
 		}
-		defer file.Close()
-		results, err := analyze(file)
+		results, tree, err := analyze(debugInfo, sections)
 		if err != nil {
 			log.Printf("error: couldn't analyze debug data for %s: %v", f, err)
 			continue
 		}
-		printSortedResults(results, *sortFlag, *formatFlag, *limitFlag)
+		switch *formatFlag {
+		case "tree":
+			printTree(tree)
+		case "flamegraph":
+			printFlamegraph(tree)
+		default:
+			printSortedResults(results, *sortFlag, *formatFlag, *limitFlag)
+		}
+	}
+}
+
+// openObjectFile opens path, sniffs its file format from its magic bytes, and dispatches to
+// the appropriate front-end to extract its DWARF data and debugSections.
+func openObjectFile(path string) (*dwarf.Data, debugSections, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, debugSections{}, err
+	}
+	var magic [4]byte
+	_, err = io.ReadFull(f, magic[:])
+	f.Close()
+	if err != nil {
+		return nil, debugSections{}, fmt.Errorf("couldn't read magic: %v", err)
+	}
+
+	switch {
+	case string(magic[:]) == "\x7fELF":
+		return openELF(path)
+	case magic[0] == 'M' && magic[1] == 'Z':
+		return openPE(path)
+	case isMachOMagic(binary.BigEndian.Uint32(magic[:])), isMachOMagic(binary.LittleEndian.Uint32(magic[:])):
+		return openMachO(path)
+	default:
+		return nil, debugSections{}, fmt.Errorf("unrecognized file format (magic %x)", magic)
+	}
+}
+
+// isMachOMagic reports whether magic is one of the Mach-O magic numbers, in either 32-bit,
+// 64-bit, or fat binary form.
+func isMachOMagic(magic uint32) bool {
+	switch magic {
+	case macho.Magic32, macho.Magic64, macho.MagicFat:
+		return true
+	default:
+		return false
 	}
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// isFatMachO reports whether path starts with the fat/universal Mach-O magic, in either byte
+// order (a fat header is big-endian on disk; checking both orders also accepts a byte-swapped
+// fat magic).
+func isFatMachO(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	var magic [4]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return false, fmt.Errorf("couldn't read magic: %v", err)
+	}
+	return binary.BigEndian.Uint32(magic[:]) == macho.MagicFat || binary.LittleEndian.Uint32(magic[:]) == macho.MagicFat, nil
+}
+
+// bytesPerAddressForMachO returns the size of an address in file, in bytes.
+func bytesPerAddressForMachO(file *macho.File) (uint8, error) {
+	switch file.Magic {
+	case macho.Magic32:
+		return 4, nil
+	case macho.Magic64:
+		return 8, nil
+	default:
+		return 0, fmt.Errorf("%v has unknown magic value 0x%x", file, file.Magic)
+	}
+}
+
+// machoSectionData returns the (decompressed, if necessary) contents of the named section in
+// file, or nil if it doesn't exist.
+//
+// lld's --compress-debug-sections marks compressed sections with a leading "ZLIB" magic and an
+// 8-byte big-endian uncompressed size, the same non-standard convention debug/macho's own
+// DWARF() method unpacks for __debug_info et al.
+func machoSectionData(file *macho.File, name string) ([]byte, error) {
+	section := file.Section(name)
+	if section == nil {
+		return nil, nil
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+	return decompressZlibSection(data)
+}
+
+// openMachO opens path as a Mach-O file and extracts its DWARF data and debugSections. Range
+// list sections live in the __DWARF segment alongside .debug_info, named e.g. __debug_ranges
+// rather than .debug_ranges.
+func openMachO(path string) (*dwarf.Data, debugSections, error) {
+	if isFat, err := isFatMachO(path); err != nil {
+		return nil, debugSections{}, err
+	} else if isFat {
+		// debug/macho.Open only handles single-architecture Mach-O; a fat/universal
+		// binary bundles several architecture slices side by side, and there's no
+		// single right one for this tool to pick on the caller's behalf.
+		return nil, debugSections{}, fmt.Errorf("%s is a fat Mach-O binary; extract a single architecture with lipo before analyzing it", path)
+	}
+
+	file, err := macho.Open(path)
+	if err != nil {
+		return nil, debugSections{}, err
+	}
+	defer file.Close()
+
+	debugInfo, err := file.DWARF()
+	if err != nil {
+		return nil, debugSections{}, err
+	}
+
+	addressSize, err := bytesPerAddressForMachO(file)
+	if err != nil {
+		return nil, debugSections{}, err
+	}
+
+	sections := debugSections{byteOrder: file.ByteOrder, addressSize: addressSize}
+	if sections.ranges, err = machoSectionData(file, "__debug_ranges"); err != nil {
+		return nil, debugSections{}, err
+	}
+	if sections.rnglists, err = machoSectionData(file, "__debug_rnglists"); err != nil {
+		return nil, debugSections{}, err
+	}
+	if sections.addr, err = machoSectionData(file, "__debug_addr"); err != nil {
+		return nil, debugSections{}, err
+	}
+
+	return debugInfo, sections, nil
+}
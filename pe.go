@@ -0,0 +1,71 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+)
+
+// bytesPerAddressForPE returns the size of an address in file, in bytes.
+func bytesPerAddressForPE(file *pe.File) (uint8, error) {
+	switch file.Machine {
+	case pe.IMAGE_FILE_MACHINE_I386, pe.IMAGE_FILE_MACHINE_ARM, pe.IMAGE_FILE_MACHINE_ARMNT:
+		return 4, nil
+	case pe.IMAGE_FILE_MACHINE_AMD64, pe.IMAGE_FILE_MACHINE_ARM64:
+		return 8, nil
+	default:
+		return 0, fmt.Errorf("%v has unsupported machine type 0x%x", file, file.Machine)
+	}
+}
+
+// peSectionData returns the (decompressed, if necessary) contents of the named section in
+// file, or nil if it doesn't exist.
+//
+// MinGW toolchains may compress debug sections, marking them with a leading "ZLIB" magic and
+// an 8-byte big-endian uncompressed size, the same non-standard convention debug/pe's own
+// DWARF() method unpacks for .debug_info et al. Sections that start with the zstd frame
+// magic aren't supported; see decompressZlibSection.
+func peSectionData(file *pe.File, name string) ([]byte, error) {
+	section := file.Section(name)
+	if section == nil {
+		return nil, nil
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+	return decompressZlibSection(data)
+}
+
+// openPE opens path as a PE file and extracts its DWARF data and debugSections.
+func openPE(path string) (*dwarf.Data, debugSections, error) {
+	file, err := pe.Open(path)
+	if err != nil {
+		return nil, debugSections{}, err
+	}
+	defer file.Close()
+
+	debugInfo, err := file.DWARF()
+	if err != nil {
+		return nil, debugSections{}, err
+	}
+
+	addressSize, err := bytesPerAddressForPE(file)
+	if err != nil {
+		return nil, debugSections{}, err
+	}
+
+	sections := debugSections{byteOrder: binary.LittleEndian, addressSize: addressSize}
+	if sections.ranges, err = peSectionData(file, ".debug_ranges"); err != nil {
+		return nil, debugSections{}, err
+	}
+	if sections.rnglists, err = peSectionData(file, ".debug_rnglists"); err != nil {
+		return nil, debugSections{}, err
+	}
+	if sections.addr, err = peSectionData(file, ".debug_addr"); err != nil {
+		return nil, debugSections{}, err
+	}
+
+	return debugInfo, sections, nil
+}
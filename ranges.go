@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+)
+
+// DW_RLE_* range list entry kinds used in .debug_rnglists, per DWARFv5 section 2.17.3.
+const (
+	dwRleEndOfList    = 0x00
+	dwRleBaseAddressx = 0x01
+	dwRleStartxEndx   = 0x02
+	dwRleStartxLength = 0x03
+	dwRleOffsetPair   = 0x04
+	dwRleBaseAddress  = 0x05
+	dwRleStartEnd     = 0x06
+	dwRleStartLength  = 0x07
+)
+
+type rangeSizeMap map[int64]uint64
+
+// debugSections holds the raw range-list-related DWARF section bytes for an object file,
+// abstracted away from the file format they came from, so parseDebugRanges/parseDebugRnglists
+// and analyze don't need to know whether they're looking at an ELF, Mach-O, or PE binary.
+type debugSections struct {
+	byteOrder   binary.ByteOrder
+	addressSize uint8
+	ranges      []byte // .debug_ranges / __debug_ranges, or nil if absent.
+	rnglists    []byte // .debug_rnglists / __debug_rnglists, or nil if absent.
+	addr        []byte // .debug_addr / __debug_addr, or nil if absent.
+}
+
+// Go's debug/dwarf package doesn't include .debug_ranges parsing support.
+func parseDebugRanges(sections debugSections) (rangeSizeMap, error) {
+	log.Print("parsing .debug_ranges...")
+	if sections.ranges == nil {
+		return nil, nil
+	}
+	byteOrder := sections.byteOrder
+	bytesPerAddress := int(sections.addressSize)
+	data := sections.ranges
+
+	// The .debug_ranges format is pretty simple. A DIE may use DW_AT_ranges to refer to a
+	// range in the .debug_ranges section, which represents a range of non-contiguous
+	// addresses. Each entry in the range is a either a range list entry, a base address
+	// selection entry, or an end of list entry.
+	// - A range list entry consists of a beginning address offset and an ending address
+	//   offset. The beginning address offset may be 0x0, and the length of the range may be
+	//   0, if the beginning and ending address offsets are equal. Range list entries may
+	//   not overlap.
+	// - A base address selection entry, which consists of the largest representable
+	//   address, e.g. 0xffffffff for 32-bit addresses, and an address that defines the base
+	//   address of subsequent entries.
+	// - An end of list entry is a range list entry that has a beginning and ending address
+	//   offset of 0.
+	var currentOffset int64
+	rangeSizes := make(rangeSizeMap)
+	for pos := 0; pos < len(data); pos += 2 * bytesPerAddress {
+		if pos+2*bytesPerAddress > len(data) {
+			return nil, fmt.Errorf("read strange number of bytes at offset %d", pos)
+		}
+		var begin, end uint64
+		switch bytesPerAddress {
+		case 4:
+			begin = uint64(byteOrder.Uint32(data[pos:]))
+			end = uint64(byteOrder.Uint32(data[pos+4:]))
+			if begin == math.MaxUint32 {
+				continue
+			}
+		case 8:
+			begin = byteOrder.Uint64(data[pos:])
+			end = byteOrder.Uint64(data[pos+8:])
+			if begin == math.MaxUint64 {
+				continue
+			}
+		}
+		nextOffset := int64(pos + 2*bytesPerAddress)
+		if begin == 0 && end == 0 {
+			currentOffset = nextOffset
+			continue
+		}
+		rangeSizes[currentOffset] += end - begin
+	}
+	return rangeSizes, nil
+}
+
+// addrTable resolves DW_FORM_addrx indices against a .debug_addr section. It assumes a
+// single address table immediately following the table's 8-byte DWARFv5 header (unit_length,
+// version, address_size, segment_selector_size), which holds for the common case of one
+// compile unit's .debug_addr contribution per object file.
+type addrTable struct {
+	data        []byte
+	byteOrder   binary.ByteOrder
+	addressSize uint8
+}
+
+func (t addrTable) resolve(index uint64) (uint64, error) {
+	const headerSize = 8
+	offset := headerSize + index*uint64(t.addressSize)
+	if offset+uint64(t.addressSize) > uint64(len(t.data)) {
+		return 0, fmt.Errorf("addrx index %d out of range", index)
+	}
+	switch t.addressSize {
+	case 4:
+		return uint64(t.byteOrder.Uint32(t.data[offset:])), nil
+	case 8:
+		return t.byteOrder.Uint64(t.data[offset:]), nil
+	default:
+		return 0, fmt.Errorf("unsupported address size %d", t.addressSize)
+	}
+}
+
+// parseRnglistsUnitHeader parses the header of one per-CU contribution to .debug_rnglists, per DWARFv5
+// section 7.28: unit_length, version, address_size, segment_selector_size, and an array of
+// offset_entry_count offsets (used to resolve DW_FORM_rnglistx, though this tool relies on
+// debug/dwarf having already done that via DW_AT_rnglists_base). Ordinary, non-split-DWARF
+// output from both gcc and clang always includes this header; gcc's split DWARF (.dwo)
+// output omits it and starts straight in on range list entries.
+func parseRnglistsUnitHeader(data []byte, pos int, byteOrder binary.ByteOrder) (entriesStart, unitEnd int, ok bool) {
+	const initialHeaderSize = 4 // unit_length (32-bit DWARF form)
+	if pos+initialHeaderSize > len(data) {
+		return 0, 0, false
+	}
+	unitLength := uint64(byteOrder.Uint32(data[pos:]))
+	headerPos := pos + initialHeaderSize
+	is64BitDwarf := unitLength == 0xffffffff
+	if is64BitDwarf {
+		if headerPos+8 > len(data) {
+			return 0, 0, false
+		}
+		unitLength = byteOrder.Uint64(data[headerPos:])
+		headerPos += 8
+	}
+	unitEnd = headerPos + int(unitLength)
+	if unitLength > uint64(len(data)) || unitEnd > len(data) {
+		return 0, 0, false
+	}
+	// version+address_size+segment_selector_size+offset_entry_count
+	const restOfHeaderSize = 2 + 1 + 1 + 4
+	if headerPos+restOfHeaderSize > unitEnd {
+		return 0, 0, false
+	}
+	version := byteOrder.Uint16(data[headerPos:])
+	if version != 5 {
+		return 0, 0, false
+	}
+	offsetEntryCount := byteOrder.Uint32(data[headerPos+4:])
+	offsetSize := 4
+	if is64BitDwarf {
+		offsetSize = 8
+	}
+	entriesStart = headerPos + restOfHeaderSize + int(offsetEntryCount)*offsetSize
+	if entriesStart > unitEnd {
+		return 0, 0, false
+	}
+	return entriesStart, unitEnd, true
+}
+
+// Go's debug/dwarf package doesn't include .debug_rnglists parsing support, the DWARF 5
+// replacement for .debug_ranges that's emitted by clang/gcc under -gdwarf-5.
+//
+// Unlike .debug_ranges, a .debug_rnglists range list is a sequence of entries introduced by
+// a 1-byte DW_RLE code, some of which carry ULEB128 operands (addresses are indirected
+// through .debug_addr when the operand is an "x" variant, e.g. DW_RLE_startx_endx). The
+// section is a concatenation of one or more per-CU units, each led by a
+// parseRnglistsUnitHeader; gcc's split DWARF (.dwo) output omits that header and the whole
+// section is the entries of a single implicit unit. Either way, this keys each list's total
+// size by the offset of its first entry -- the same offset a DW_AT_ranges attribute of form
+// DW_FORM_sec_offset points to.
+func parseDebugRnglists(sections debugSections) (rangeSizeMap, error) {
+	log.Print("parsing .debug_rnglists...")
+	if sections.rnglists == nil {
+		return nil, nil
+	}
+	byteOrder := sections.byteOrder
+	bytesPerAddress := int(sections.addressSize)
+	data := sections.rnglists
+
+	addrs := addrTable{data: sections.addr, byteOrder: byteOrder, addressSize: sections.addressSize}
+
+	readAddress := func(pos int) (uint64, int, error) {
+		if pos+bytesPerAddress > len(data) {
+			return 0, pos, fmt.Errorf("unexpected end of .debug_rnglists at offset %d", pos)
+		}
+		var addr uint64
+		switch bytesPerAddress {
+		case 4:
+			addr = uint64(byteOrder.Uint32(data[pos:]))
+		case 8:
+			addr = byteOrder.Uint64(data[pos:])
+		}
+		return addr, pos + bytesPerAddress, nil
+	}
+	readULEB := func(pos int) (uint64, int, error) {
+		v, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			return 0, pos, fmt.Errorf("malformed ULEB128 at offset %d", pos)
+		}
+		return v, pos + n, nil
+	}
+
+	rangeSizes := make(rangeSizeMap)
+	for unitStart := 0; unitStart < len(data); {
+		entriesStart, unitEnd, ok := parseRnglistsUnitHeader(data, unitStart, byteOrder)
+		if !ok {
+			// No (more) per-unit header: either this is split-DWARF output, which omits
+			// it entirely, or the section simply ends here. Either way, treat the rest of
+			// the section as one implicit unit's worth of entries.
+			entriesStart, unitEnd = unitStart, len(data)
+		}
+		currentOffset := int64(entriesStart)
+		for pos := entriesStart; pos < unitEnd; {
+			code := data[pos]
+			pos++
+			switch code {
+			case dwRleEndOfList:
+				currentOffset = int64(pos)
+			case dwRleBaseAddressx:
+				// The base address only matters for resolving DW_RLE_offset_pair entries
+				// to absolute addresses, which this tool doesn't need: summing byte counts
+				// only cares about the (base-independent) difference between offsets.
+				if _, next, err := readULEB(pos); err != nil {
+					return nil, err
+				} else {
+					pos = next
+				}
+			case dwRleStartxEndx:
+				startIdx, next, err := readULEB(pos)
+				if err != nil {
+					return nil, err
+				}
+				pos = next
+				endIdx, next, err := readULEB(pos)
+				if err != nil {
+					return nil, err
+				}
+				pos = next
+				start, err := addrs.resolve(startIdx)
+				if err != nil {
+					return nil, err
+				}
+				end, err := addrs.resolve(endIdx)
+				if err != nil {
+					return nil, err
+				}
+				rangeSizes[currentOffset] += end - start
+			case dwRleStartxLength:
+				if _, next, err := readULEB(pos); err != nil {
+					return nil, err
+				} else {
+					pos = next
+				}
+				length, next, err := readULEB(pos)
+				if err != nil {
+					return nil, err
+				}
+				pos = next
+				rangeSizes[currentOffset] += length
+			case dwRleOffsetPair:
+				start, next, err := readULEB(pos)
+				if err != nil {
+					return nil, err
+				}
+				pos = next
+				end, next, err := readULEB(pos)
+				if err != nil {
+					return nil, err
+				}
+				pos = next
+				rangeSizes[currentOffset] += end - start
+			case dwRleBaseAddress:
+				if _, next, err := readAddress(pos); err != nil {
+					return nil, err
+				} else {
+					pos = next
+				}
+			case dwRleStartEnd:
+				start, next, err := readAddress(pos)
+				if err != nil {
+					return nil, err
+				}
+				pos = next
+				end, next, err := readAddress(pos)
+				if err != nil {
+					return nil, err
+				}
+				pos = next
+				rangeSizes[currentOffset] += end - start
+			case dwRleStartLength:
+				if _, next, err := readAddress(pos); err != nil {
+					return nil, err
+				} else {
+					pos = next
+				}
+				length, next, err := readULEB(pos)
+				if err != nil {
+					return nil, err
+				}
+				pos = next
+				rangeSizes[currentOffset] += length
+			default:
+				return nil, fmt.Errorf("unknown DW_RLE entry 0x%x at offset %d", code, pos-1)
+			}
+		}
+		unitStart = unitEnd
+	}
+	return rangeSizes, nil
+}
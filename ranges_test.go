@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// uleb encodes v as ULEB128, matching the encoding parseDebugRnglists decodes via
+// binary.Uvarint.
+func uleb(v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, v)
+	return buf[:n]
+}
+
+func TestParseDebugRnglists(t *testing.T) {
+	le := binary.LittleEndian
+
+	tests := []struct {
+		name     string
+		sections debugSections
+		want     rangeSizeMap
+		wantErr  bool
+	}{
+		{
+			name: "offset pair, headerless (split DWARF)",
+			sections: debugSections{
+				byteOrder:   le,
+				addressSize: 8,
+				rnglists: append(
+					append([]byte{dwRleOffsetPair}, append(uleb(0x10), uleb(0x30)...)...),
+					dwRleEndOfList,
+				),
+			},
+			want: rangeSizeMap{0: 0x20},
+		},
+		{
+			name: "two lists back to back, headerless",
+			sections: debugSections{
+				byteOrder:   le,
+				addressSize: 8,
+				rnglists: concatBytes(
+					[]byte{dwRleOffsetPair}, uleb(0), uleb(0x10), []byte{dwRleEndOfList},
+					[]byte{dwRleOffsetPair}, uleb(0), uleb(0x40), []byte{dwRleEndOfList},
+				),
+			},
+			want: rangeSizeMap{0: 0x10, 4: 0x40},
+		},
+		{
+			name: "start_end and start_length, absolute addresses",
+			sections: debugSections{
+				byteOrder:   le,
+				addressSize: 8,
+				rnglists: concatBytes(
+					[]byte{dwRleStartEnd}, addr(le, 0x1000), addr(le, 0x1020),
+					[]byte{dwRleStartLength}, addr(le, 0x2000), uleb(0x8),
+					[]byte{dwRleEndOfList},
+				),
+			},
+			want: rangeSizeMap{0: 0x28},
+		},
+		{
+			name: "startx_endx and startx_length, indexed through .debug_addr",
+			sections: debugSections{
+				byteOrder:   le,
+				addressSize: 8,
+				rnglists: concatBytes(
+					[]byte{dwRleStartxEndx}, uleb(0), uleb(1),
+					[]byte{dwRleStartxLength}, uleb(1), uleb(0x30),
+					[]byte{dwRleEndOfList},
+				),
+				addr: concatBytes(
+					make([]byte, 8), // 8-byte DWARFv5 .debug_addr header, contents unused.
+					addr(le, 0x4000),
+					addr(le, 0x4100),
+				),
+			},
+			want: rangeSizeMap{0: 0x100 + 0x30},
+		},
+		{
+			name: "base_addressx and base_address are skipped without affecting byte counts",
+			sections: debugSections{
+				byteOrder:   le,
+				addressSize: 8,
+				rnglists: concatBytes(
+					[]byte{dwRleBaseAddressx}, uleb(0),
+					[]byte{dwRleBaseAddress}, addr(le, 0x5000),
+					[]byte{dwRleOffsetPair}, uleb(0), uleb(0x18),
+					[]byte{dwRleEndOfList},
+				),
+			},
+			want: rangeSizeMap{0: 0x18},
+		},
+		{
+			name: "per-CU unit header is skipped to reach the entries",
+			sections: debugSections{
+				byteOrder:   le,
+				addressSize: 8,
+				rnglists:    rnglistsUnitWithEntries(le, []byte{dwRleOffsetPair}, uleb(0), uleb(0x50), []byte{dwRleEndOfList}),
+			},
+			want: rangeSizeMap{12: 0x50}, // entriesStart == header size for offset_entry_count == 0.
+		},
+		{
+			name: "unrecognized DW_RLE code is an error",
+			sections: debugSections{
+				byteOrder:   le,
+				addressSize: 8,
+				rnglists:    []byte{0x36},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "absent section returns nil map",
+			sections: debugSections{byteOrder: le, addressSize: 8},
+			want:     nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDebugRnglists(tt.sections)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDebugRnglists() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDebugRnglists() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func addr(byteOrder binary.ByteOrder, v uint64) []byte {
+	buf := make([]byte, 8)
+	byteOrder.PutUint64(buf, v)
+	return buf
+}
+
+// rnglistsUnitWithEntries wraps entries in a DWARFv5 .debug_rnglists per-CU unit header (32-bit
+// DWARF, address_size 8, no offset table) as parseRnglistsUnitHeader expects.
+func rnglistsUnitWithEntries(byteOrder binary.ByteOrder, entryParts ...[]byte) []byte {
+	entries := concatBytes(entryParts...)
+	const restOfHeaderSize = 2 + 1 + 1 + 4 // version + address_size + segment_selector_size + offset_entry_count
+	rest := make([]byte, restOfHeaderSize)
+	byteOrder.PutUint16(rest[0:], 5) // version
+	rest[2] = 8                      // address_size
+	rest[3] = 0                      // segment_selector_size
+	byteOrder.PutUint32(rest[4:], 0) // offset_entry_count
+
+	unitLength := uint32(len(rest) + len(entries))
+	header := make([]byte, 4)
+	byteOrder.PutUint32(header, unitLength)
+
+	return concatBytes(header, rest, entries)
+}